@@ -4,15 +4,20 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"flag"
+	"hash"
 	"io"
 	"log"
 	"net/http"
+	"os"
 	"regexp"
+	"sort"
 	"strconv"
 
 	"github.com/containerd/containerd"
 	"github.com/containerd/containerd/content"
 	"github.com/containerd/containerd/defaults"
+	"github.com/containerd/containerd/namespaces"
 	"github.com/containerd/containerd/reference/docker"
 
 	"github.com/rogpeppe/ociregistry"
@@ -20,47 +25,75 @@ import (
 )
 
 // caller responsible for client.Close!
-func newContainerdClient() (*containerd.Client, error) {
-	// TODO environment variables (CONTAINERD_ADDRESS, CONTAINERD_NAMESPACE)
+func newContainerdClient(defaultNamespace string) (*containerd.Client, error) {
+	// TODO environment variables (CONTAINERD_ADDRESS)
 	return containerd.New(
 		defaults.DefaultAddress,
-		containerd.WithDefaultNamespace("default"),
+		containerd.WithDefaultNamespace(defaultNamespace),
 	)
 }
 
 type containerdRegistry struct {
 	*ociregistry.Funcs
-	client *containerd.Client
+	client    *containerd.Client
+	referrers *referrersCache
+	ns        *namespaceRouter
+}
+
+// dedupeSortedStrings removes adjacent duplicates from a sorted slice,
+// reusing its backing array.
+func dedupeSortedStrings(ss []string) []string {
+	out := ss[:0]
+	for i, s := range ss {
+		if i == 0 || s != out[len(out)-1] {
+			out = append(out, s)
+		}
+	}
+	return out
 }
 
 func (r containerdRegistry) Repositories(ctx context.Context) ociregistry.Iter[string] {
 	is := r.client.ImageService()
 
-	images, err := is.List(ctx)
-	if err != nil {
-		return ociregistry.ErrorIter[string](err)
-	}
-
 	names := []string{}
-	for _, image := range images {
-		// image.Name is a fully qualified name like "repo:tag" or "repo@digest" so we need to parse it so we can return just the repo name list
-		ref, err := docker.ParseNormalizedNamed(image.Name)
+	for _, ns := range r.ns.namespacesToEnumerate() {
+		nsCtx := namespaces.WithNamespace(ctx, ns)
+
+		images, err := is.List(nsCtx)
 		if err != nil {
-			// just ignore images whose names we can't parse (TODO debug log?)
-			continue
+			return ociregistry.ErrorIter[string](err)
 		}
-		repo := ref.Name()
-		if len(names) > 0 && names[len(names)-1] == repo {
-			// "List" returns sorted order, so we only need to check the last item in the list to dedupe
-			continue
+
+		for _, image := range images {
+			// image.Name is a fully qualified name like "repo:tag" or "repo@digest" so we need to parse it so we can return just the repo name list
+			ref, err := docker.ParseNormalizedNamed(image.Name)
+			if err != nil {
+				// just ignore images whose names we can't parse (TODO debug log?)
+				continue
+			}
+			repo := r.ns.join(ns, ref.Name())
+			if len(names) > 0 && names[len(names)-1] == repo {
+				// within a single namespace "List" returns sorted order, so we only need to check the last item in the list to dedupe
+				continue
+			}
+			names = append(names, repo)
 		}
-		names = append(names, repo)
 	}
 
+	// Namespaces are enumerated in whatever order namespacesToEnumerate
+	// hands them back, and ociserver's catalog pagination assumes the
+	// whole list it gets from Repositories is lexically ordered - so the
+	// per-namespace-sorted chunks above aren't enough on their own once
+	// there's more than one namespace. Sort (and re-dedupe, since sorting
+	// can make namespaces' chunks interleave) before returning.
+	sort.Strings(names)
+	names = dedupeSortedStrings(names)
+
 	return ociregistry.SliceIter[string](names)
 }
 
 func (r containerdRegistry) Tags(ctx context.Context, repo string) ociregistry.Iter[string] {
+	ctx, repo = r.withNamespace(ctx, repo)
 
 	is := r.client.ImageService()
 
@@ -97,6 +130,13 @@ type containerdBlobReader struct {
 
 	readerAt content.ReaderAt
 	reader   io.Reader
+	section  *io.SectionReader // lazily set once ReadAt/Seek is used; see rangereader.go
+
+	// rangeHash/rangeNext/rangePos track digest verification across the
+	// ReadAt/Seek path; see rangereader.go.
+	rangeHash hash.Hash
+	rangeNext int64
+	rangePos  int64
 }
 
 func (br *containerdBlobReader) validate() error {
@@ -131,12 +171,35 @@ func (br *containerdBlobReader) ensureReader() (io.Reader, error) {
 		if err != nil {
 			return nil, err
 		}
-		br.reader = content.NewReader(ra)
+		// br.desc.Digest is set when the reader is constructed and
+		// br.desc.Size comes from validate() (or the image target, for
+		// GetTag), so we can verify the bytes as we stream them out
+		// instead of trusting the content store blindly.
+		br.reader = newVerifyingReader(content.NewReader(ra), br.desc)
 	}
 	return br.reader, nil
 }
 
 func (br *containerdBlobReader) Read(p []byte) (int, error) {
+	if br.section != nil {
+		// ReadAt/Seek has been used to request a byte range; see
+		// recordRangeBytes in rangereader.go for how we still verify the
+		// digest when this path ends up covering the whole blob (e.g. a
+		// "bytes=0-" request that's really asking for everything).
+		n, err := br.section.Read(p)
+		if n > 0 {
+			br.recordRangeBytes(br.rangePos, p[:n])
+			br.rangePos += int64(n)
+		}
+		// as in ReadAt, a read that exactly reaches the end can legally
+		// come back with (n, nil) instead of (n, io.EOF).
+		if err == io.EOF || (n > 0 && br.rangePos >= br.desc.Size) {
+			if verr := br.checkRangeVerification(); verr != nil {
+				return n, verr
+			}
+		}
+		return n, err
+	}
 	r, err := br.ensureReader()
 	if err != nil {
 		return 0, err
@@ -178,11 +241,13 @@ func newContainerdBlobReaderFromDigest(ctx context.Context, client *containerd.C
 }
 
 func (r containerdRegistry) GetBlob(ctx context.Context, repo string, digest ociregistry.Digest) (ociregistry.BlobReader, error) {
+	ctx, _ = r.withNamespace(ctx, repo)
 	// TODO convert not found into proper 404 errors
 	return newContainerdBlobReaderFromDigest(ctx, r.client, digest)
 }
 
 func (r containerdRegistry) GetManifest(ctx context.Context, repo string, digest ociregistry.Digest) (ociregistry.BlobReader, error) {
+	ctx, _ = r.withNamespace(ctx, repo)
 
 	// we can technically just return the manifest directly from the content store, but we need the "right" MediaType value for the Content-Type header (and thanks to https://github.com/opencontainers/image-spec/security/advisories/GHSA-77vh-xpmg-72qh we can safely assume manifests have "mediaType" set for us to parse this value out of or else they're manifests we don't care to support!)
 	desc := ociregistry.Descriptor{Digest: digest}
@@ -195,8 +260,7 @@ func (r containerdRegistry) GetManifest(ctx context.Context, repo string, digest
 	mediaTypeWrapper := struct {
 		MediaType string `json:"mediaType"`
 	}{}
-	// TODO add a limitedreader here to make sure we don't read an enormous amount of valid but useless JSON that DoS's us
-	if err := json.NewDecoder(content.NewReader(ra)).Decode(&mediaTypeWrapper); err != nil {
+	if err := json.NewDecoder(io.LimitReader(content.NewReader(ra), maxManifestSize)).Decode(&mediaTypeWrapper); err != nil {
 		return nil, err
 	}
 	if mediaTypeWrapper.MediaType == "" {
@@ -210,6 +274,7 @@ func (r containerdRegistry) GetManifest(ctx context.Context, repo string, digest
 }
 
 func (r containerdRegistry) GetTag(ctx context.Context, repo string, tagName string) (ociregistry.BlobReader, error) {
+	ctx, repo = r.withNamespace(ctx, repo)
 	is := r.client.ImageService()
 
 	img, err := is.Get(ctx, repo+":"+tagName)
@@ -255,14 +320,27 @@ func (r containerdRegistry) ResolveTag(ctx context.Context, repo string, tagName
 }
 
 func main() {
-	client, err := newContainerdClient()
+	flag.Parse()
+
+	defaultNamespace := os.Getenv("CONTAINERD_NAMESPACE")
+	if defaultNamespace == "" {
+		defaultNamespace = "default"
+	}
+
+	client, err := newContainerdClient(defaultNamespace)
 	if err != nil {
 		log.Fatal(err)
 	}
-	server := ociserver.New(&containerdRegistry{
-		client: client,
-	}, nil)
+	registry := ociregistry.Interface(&containerdRegistry{
+		client:    client,
+		referrers: newReferrersCache(client),
+		ns:        newNamespaceRouterFromFlag(*namespacesFlag, defaultNamespace),
+	})
+	if notifier := notifierFromFlags(); notifier != nil {
+		registry = &notifyingRegistry{Interface: registry, notifier: notifier}
+	}
+	server := ociserver.New(registry, nil)
 	println("listening on http://*:5000")
 	// TODO listen address/port should be configurable somehow
-	panic(http.ListenAndServe(":5000", server))
+	panic(http.ListenAndServe(":5000", withRequestInfo(server)))
 }
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd/namespaces"
+)
+
+// namespaceRouter maps the first path segment of an incoming repo name
+// (e.g. "k8s.io/pause" -> namespace "k8s.io", repo "pause") onto a
+// containerd namespace, so a single registry endpoint can multiplex
+// several containerd namespaces instead of always hitting "default".
+type namespaceRouter struct {
+	// defaultNamespace is used for any repo whose first segment doesn't
+	// match an entry in allowed (or when allowed is empty).
+	defaultNamespace string
+	// allowed is the set of namespaces we'll route to based on a repo
+	// prefix; empty means multiplexing is off and everything goes to
+	// defaultNamespace.
+	allowed map[string]bool
+	// enumerate, when true, makes Repositories walk every namespace in
+	// allowed (rather than just defaultNamespace).
+	enumerate bool
+}
+
+// newNamespaceRouterFromFlag parses the --namespaces flag value (and, if
+// unset, the CONTAINERD_NAMESPACE environment variable) into a
+// namespaceRouter. The flag value is a comma-separated list of namespaces,
+// e.g. "default,k8s.io,moby"; a leading "*," enables cross-namespace
+// enumeration in Repositories.
+func newNamespaceRouterFromFlag(spec string, defaultNamespace string) *namespaceRouter {
+	nr := &namespaceRouter{defaultNamespace: defaultNamespace}
+
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nr
+	}
+
+	allowed := map[string]bool{defaultNamespace: true}
+	for _, ns := range strings.Split(spec, ",") {
+		ns = strings.TrimSpace(ns)
+		switch ns {
+		case "":
+			continue
+		case "*":
+			nr.enumerate = true
+		default:
+			allowed[ns] = true
+		}
+	}
+	nr.allowed = allowed
+	return nr
+}
+
+// split picks apart a namespace prefix from repo, returning the containerd
+// namespace to use and the repo name with that prefix removed. If repo's
+// first segment isn't a recognized namespace (or multiplexing is off),
+// defaultNamespace is returned unchanged and repo is left untouched.
+func (nr *namespaceRouter) split(repo string) (namespace, localRepo string) {
+	if len(nr.allowed) == 0 {
+		return nr.defaultNamespace, repo
+	}
+	if i := strings.IndexByte(repo, '/'); i >= 0 {
+		if first := repo[:i]; nr.allowed[first] {
+			return first, repo[i+1:]
+		}
+	}
+	return nr.defaultNamespace, repo
+}
+
+// join is the inverse of split, used when building repo names to return
+// from Repositories: it only adds a prefix for non-default namespaces, so
+// "default" repos keep looking exactly as they did before namespacing.
+func (nr *namespaceRouter) join(namespace, localRepo string) string {
+	if namespace == nr.defaultNamespace {
+		return localRepo
+	}
+	return namespace + "/" + localRepo
+}
+
+// namespacesToEnumerate returns the namespaces Repositories should walk:
+// just defaultNamespace unless cross-namespace enumeration is enabled.
+func (nr *namespaceRouter) namespacesToEnumerate() []string {
+	if !nr.enumerate || len(nr.allowed) == 0 {
+		return []string{nr.defaultNamespace}
+	}
+	nss := make([]string, 0, len(nr.allowed))
+	for ns := range nr.allowed {
+		nss = append(nss, ns)
+	}
+	sort.Strings(nss)
+	return nss
+}
+
+// withNamespace splits the namespace prefix off repo and returns a context
+// scoped to that containerd namespace, plus the remaining repo name to use
+// against ImageService/ContentStore.
+func (r containerdRegistry) withNamespace(ctx context.Context, repo string) (context.Context, string) {
+	ns, localRepo := r.ns.split(repo)
+	return namespaces.WithNamespace(ctx, ns), localRepo
+}
+
+// namespacesFlagDefault supplies the --namespaces flag's default from the
+// CONTAINERD_NAMESPACES environment variable, or "" if it is unset. An
+// empty --namespaces value turns multiplexing off, leaving only the single
+// namespace main() takes from CONTAINERD_NAMESPACE (or "default").
+func namespacesFlagDefault() string {
+	if v := os.Getenv("CONTAINERD_NAMESPACES"); v != "" {
+		return v
+	}
+	return ""
+}
+
+var namespacesFlag = flag.String("namespaces", namespacesFlagDefault(), "comma-separated list of containerd namespaces to expose, routed by repo prefix (e.g. \"default,k8s.io\"); prefix with \"*,\" to enumerate all of them in repository listings")
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/containerd/containerd/namespaces"
+)
+
+// TestWithNamespaceIsNotIdempotent pins down the exact failure mode behind
+// the chunk0-3 push bug: calling withNamespace a second time on a repo that
+// has already had its namespace prefix stripped can't find that prefix
+// again and silently falls back to defaultNamespace. PushManifest used to
+// trigger this by calling PushBlob (which calls withNamespace itself) with
+// an already-namespaced repo; it now calls the namespace-agnostic pushBlob
+// helper instead, which never re-derives the namespace. This test
+// documents the footgun so nothing reintroduces a second withNamespace
+// call on an already-stripped repo.
+func TestWithNamespaceIsNotIdempotent(t *testing.T) {
+	r := containerdRegistry{ns: newNamespaceRouterFromFlag("k8s.io", "default")}
+
+	ctx, repo := r.withNamespace(context.Background(), "k8s.io/pause")
+	if ns, _ := namespaces.Namespace(ctx); ns != "k8s.io" {
+		t.Fatalf("withNamespace(%q) namespace = %q, want %q", "k8s.io/pause", ns, "k8s.io")
+	}
+	if repo != "pause" {
+		t.Fatalf("withNamespace(%q) repo = %q, want %q", "k8s.io/pause", repo, "pause")
+	}
+
+	// Re-deriving the namespace from the already-stripped repo regresses to
+	// defaultNamespace - this is the bug, not the desired behavior. Any
+	// code path that writes content (PushBlob, PushManifest, ...) must
+	// call withNamespace at most once per repo on the way in.
+	ctx2, repo2 := r.withNamespace(ctx, repo)
+	if ns, _ := namespaces.Namespace(ctx2); ns != "default" {
+		t.Fatalf("double withNamespace(%q) namespace = %q, want %q (regression from %q)", repo, ns, "default", "k8s.io")
+	}
+	if repo2 != repo {
+		t.Fatalf("double withNamespace(%q) repo = %q, want unchanged %q", repo, repo2, repo)
+	}
+}
+
+// TestNamespacesToEnumerateIsSorted guards against namespacesToEnumerate
+// handing back map iteration order (which Go deliberately randomizes), so
+// Repositories walks namespaces in a stable, lexically sorted order.
+func TestNamespacesToEnumerateIsSorted(t *testing.T) {
+	nr := newNamespaceRouterFromFlag("*,k8s.io,moby,zzz,aaa", "default")
+
+	for i := 0; i < 10; i++ {
+		nss := nr.namespacesToEnumerate()
+		if !sort.StringsAreSorted(nss) {
+			t.Fatalf("namespacesToEnumerate() = %v, not sorted", nss)
+		}
+	}
+}
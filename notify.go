@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/rogpeppe/ociregistry"
+)
+
+// Event describes a single registry operation, modelled after the event
+// envelope used by Docker distribution's notifications package: one entry
+// per pull/push/mount/delete, carrying enough about the target and the
+// originating request for an audit pipeline to make sense of it on its own.
+type Event struct {
+	Action    string      `json:"action"` // "pull", "push", "mount", "delete"
+	Timestamp time.Time   `json:"timestamp"`
+	Target    EventTarget `json:"target"`
+	RequestID string      `json:"requestId,omitempty"`
+	Source    string      `json:"sourceAddr,omitempty"`
+}
+
+// EventTarget identifies what an Event happened to.
+type EventTarget struct {
+	Repository string             `json:"repository"`
+	Digest     ociregistry.Digest `json:"digest,omitempty"`
+	MediaType  string             `json:"mediaType,omitempty"`
+	Size       int64              `json:"size,omitempty"`
+	Tag        string             `json:"tag,omitempty"`
+}
+
+// NotificationSink receives batches of events. Implementations should not
+// block the caller for long; Notifier already fans batches out to sinks on
+// their own goroutines, but a slow Send still delays that sink's retries.
+type NotificationSink interface {
+	Send(ctx context.Context, events []Event) error
+}
+
+// Notifier batches events from many concurrent registry operations and
+// fans each batch out to every configured sink. Construct with NewNotifier;
+// a nil *Notifier is valid and simply drops events, so callers don't need
+// to special-case "no sinks configured".
+type Notifier struct {
+	sinks  []NotificationSink
+	events chan Event
+}
+
+const (
+	notifierQueueSize  = 1024
+	notifierBatchMax   = 64
+	notifierBatchEvery = 2 * time.Second
+)
+
+func NewNotifier(sinks ...NotificationSink) *Notifier {
+	if len(sinks) == 0 {
+		return nil
+	}
+	n := &Notifier{
+		sinks:  sinks,
+		events: make(chan Event, notifierQueueSize),
+	}
+	go n.run()
+	return n
+}
+
+func (n *Notifier) emit(ev Event) {
+	if n == nil {
+		return
+	}
+	select {
+	case n.events <- ev:
+	default:
+		log.Printf("notify: dropping %s event for %s, queue full", ev.Action, ev.Target.Repository)
+	}
+}
+
+func (n *Notifier) run() {
+	ticker := time.NewTicker(notifierBatchEvery)
+	defer ticker.Stop()
+
+	var batch []Event
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		n.dispatch(batch)
+		batch = nil
+	}
+
+	for {
+		select {
+		case ev, ok := <-n.events:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, ev)
+			if len(batch) >= notifierBatchMax {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (n *Notifier) dispatch(batch []Event) {
+	for _, sink := range n.sinks {
+		go func(sink NotificationSink) {
+			if err := sink.Send(context.Background(), batch); err != nil {
+				log.Printf("notify: sink error: %v", err)
+			}
+		}(sink)
+	}
+}
+
+// StdoutSink writes one JSON object per line per event, e.g. for feeding
+// into a log collector.
+type StdoutSink struct{}
+
+func (StdoutSink) Send(ctx context.Context, events []Event) error {
+	enc := json.NewEncoder(os.Stdout)
+	for _, ev := range events {
+		if err := enc.Encode(ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// webhookEnvelope is the batched POST body sent to a WebhookSink's URL.
+type webhookEnvelope struct {
+	Events []Event `json:"events"`
+}
+
+// WebhookSink POSTs batches of events as a single JSON envelope, retrying
+// with exponential backoff on failure.
+type WebhookSink struct {
+	URL        string
+	Client     *http.Client
+	MaxRetries int // defaults to 3
+}
+
+func (w *WebhookSink) Send(ctx context.Context, events []Event) error {
+	body, err := json.Marshal(webhookEnvelope{Events: events})
+	if err != nil {
+		return err
+	}
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	maxRetries := w.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = 3
+	}
+
+	backoff := 500 * time.Millisecond
+	var lastErr error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = err
+		} else {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return nil
+			}
+			lastErr = fmt.Errorf("webhook sink: unexpected status %s", resp.Status)
+		}
+
+		if attempt == maxRetries {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	return lastErr
+}
+
+var (
+	notifyStdoutFlag  = flag.Bool("notify-stdout", os.Getenv("NOTIFY_STDOUT") == "1", "emit registry events as JSON lines on stdout")
+	notifyWebhookFlag = flag.String("notify-webhook", os.Getenv("NOTIFY_WEBHOOK_URL"), "URL to POST batched JSON registry events to")
+)
+
+// notifierFromFlags builds a Notifier from the --notify-* flags, or returns
+// nil if no sinks were configured.
+func notifierFromFlags() *Notifier {
+	var sinks []NotificationSink
+	if *notifyStdoutFlag {
+		sinks = append(sinks, StdoutSink{})
+	}
+	if *notifyWebhookFlag != "" {
+		sinks = append(sinks, &WebhookSink{URL: *notifyWebhookFlag})
+	}
+	return NewNotifier(sinks...)
+}
+
+// requestInfo carries the bits of the originating HTTP request that Events
+// want to report, threaded through via the request context since
+// ociregistry.Interface methods don't take *http.Request directly.
+type requestInfo struct {
+	id   string
+	addr string
+}
+
+type requestInfoKey struct{}
+
+// withRequestInfo is HTTP middleware that stashes a requestInfo in the
+// request context for notifyingRegistry to pick up; wrap the ociserver
+// handler with it in main().
+func withRequestInfo(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-Id")
+		if id == "" {
+			id = newRequestID()
+		}
+		ctx := context.WithValue(r.Context(), requestInfoKey{}, requestInfo{
+			id:   id,
+			addr: r.RemoteAddr,
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+func requestInfoFromContext(ctx context.Context) requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(requestInfo)
+	return info
+}
+
+// notifyingRegistry wraps an ociregistry.Interface, emitting an Event for
+// every successful pull/push/mount/delete without the wrapped
+// implementation needing to know notifications exist.
+type notifyingRegistry struct {
+	ociregistry.Interface
+	notifier *Notifier
+}
+
+func (r *notifyingRegistry) emit(ctx context.Context, action, repo string, desc ociregistry.Descriptor, tag string) {
+	info := requestInfoFromContext(ctx)
+	r.notifier.emit(Event{
+		Action:    action,
+		Timestamp: time.Now(),
+		Target: EventTarget{
+			Repository: repo,
+			Digest:     desc.Digest,
+			MediaType:  desc.MediaType,
+			Size:       desc.Size,
+			Tag:        tag,
+		},
+		RequestID: info.id,
+		Source:    info.addr,
+	})
+}
+
+func (r *notifyingRegistry) GetBlob(ctx context.Context, repo string, digest ociregistry.Digest) (ociregistry.BlobReader, error) {
+	br, err := r.Interface.GetBlob(ctx, repo, digest)
+	if err == nil {
+		r.emit(ctx, "pull", repo, br.Descriptor(), "")
+	}
+	return br, err
+}
+
+func (r *notifyingRegistry) GetManifest(ctx context.Context, repo string, digest ociregistry.Digest) (ociregistry.BlobReader, error) {
+	br, err := r.Interface.GetManifest(ctx, repo, digest)
+	if err == nil {
+		r.emit(ctx, "pull", repo, br.Descriptor(), "")
+	}
+	return br, err
+}
+
+func (r *notifyingRegistry) GetTag(ctx context.Context, repo string, tagName string) (ociregistry.BlobReader, error) {
+	br, err := r.Interface.GetTag(ctx, repo, tagName)
+	if err == nil {
+		r.emit(ctx, "pull", repo, br.Descriptor(), tagName)
+	}
+	return br, err
+}
+
+func (r *notifyingRegistry) PushBlob(ctx context.Context, repo string, desc ociregistry.Descriptor, rd io.Reader) (ociregistry.Descriptor, error) {
+	pushed, err := r.Interface.PushBlob(ctx, repo, desc, rd)
+	if err == nil {
+		r.emit(ctx, "push", repo, pushed, "")
+	}
+	return pushed, err
+}
+
+func (r *notifyingRegistry) PushBlobChunked(ctx context.Context, repo string, chunk io.Reader, offset int64) (ociregistry.BlobWriter, error) {
+	bw, err := r.Interface.PushBlobChunked(ctx, repo, chunk, offset)
+	if err != nil {
+		return nil, err
+	}
+	return &notifyingBlobWriter{BlobWriter: bw, reg: r, repo: repo}, nil
+}
+
+func (r *notifyingRegistry) PushBlobChunkedResume(ctx context.Context, repo string, id string, offset int64) (ociregistry.BlobWriter, error) {
+	bw, err := r.Interface.PushBlobChunkedResume(ctx, repo, id, offset)
+	if err != nil {
+		return nil, err
+	}
+	return &notifyingBlobWriter{BlobWriter: bw, reg: r, repo: repo}, nil
+}
+
+func (r *notifyingRegistry) MountBlob(ctx context.Context, fromRepo, toRepo string, digest ociregistry.Digest) (ociregistry.Descriptor, error) {
+	desc, err := r.Interface.MountBlob(ctx, fromRepo, toRepo, digest)
+	if err == nil {
+		r.emit(ctx, "mount", toRepo, desc, "")
+	}
+	return desc, err
+}
+
+func (r *notifyingRegistry) PushManifest(ctx context.Context, repo string, tag string, contents []byte, mediaType string) (ociregistry.Descriptor, error) {
+	desc, err := r.Interface.PushManifest(ctx, repo, tag, contents, mediaType)
+	if err == nil {
+		r.emit(ctx, "push", repo, desc, tag)
+	}
+	return desc, err
+}
+
+func (r *notifyingRegistry) PushTag(ctx context.Context, repo string, tagName string, desc ociregistry.Descriptor) error {
+	err := r.Interface.PushTag(ctx, repo, tagName, desc)
+	if err == nil {
+		r.emit(ctx, "push", repo, desc, tagName)
+	}
+	return err
+}
+
+func (r *notifyingRegistry) DeleteBlob(ctx context.Context, repo string, digest ociregistry.Digest) error {
+	err := r.Interface.DeleteBlob(ctx, repo, digest)
+	if err == nil {
+		r.emit(ctx, "delete", repo, ociregistry.Descriptor{Digest: digest}, "")
+	}
+	return err
+}
+
+func (r *notifyingRegistry) DeleteManifest(ctx context.Context, repo string, digest ociregistry.Digest) error {
+	err := r.Interface.DeleteManifest(ctx, repo, digest)
+	if err == nil {
+		r.emit(ctx, "delete", repo, ociregistry.Descriptor{Digest: digest}, "")
+	}
+	return err
+}
+
+func (r *notifyingRegistry) DeleteTag(ctx context.Context, repo string, tagName string) error {
+	err := r.Interface.DeleteTag(ctx, repo, tagName)
+	if err == nil {
+		r.emit(ctx, "delete", repo, ociregistry.Descriptor{}, tagName)
+	}
+	return err
+}
+
+// notifyingBlobWriter fires a "push" event once a chunked upload commits
+// successfully; PushBlob's monolithic path emits directly from
+// notifyingRegistry.PushBlob instead.
+type notifyingBlobWriter struct {
+	ociregistry.BlobWriter
+	reg  *notifyingRegistry
+	repo string
+}
+
+func (bw *notifyingBlobWriter) Commit(ctx context.Context, size int64, digest ociregistry.Digest) (ociregistry.Descriptor, error) {
+	desc, err := bw.BlobWriter.Commit(ctx, size, digest)
+	if err == nil {
+		bw.reg.emit(ctx, "push", bw.repo, desc, "")
+	}
+	return desc, err
+}
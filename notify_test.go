@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rogpeppe/ociregistry"
+)
+
+// sinkFunc adapts a plain function to NotificationSink, for tests.
+type sinkFunc func(ctx context.Context, events []Event) error
+
+func (f sinkFunc) Send(ctx context.Context, events []Event) error { return f(ctx, events) }
+
+func TestNotifierDispatchSendsToAllSinks(t *testing.T) {
+	received := make(chan []Event, 1)
+	sink := sinkFunc(func(ctx context.Context, events []Event) error {
+		received <- events
+		return nil
+	})
+	n := &Notifier{sinks: []NotificationSink{sink}}
+	ev := Event{Action: "pull", Target: EventTarget{Repository: "library/busybox"}}
+	n.dispatch([]Event{ev})
+
+	select {
+	case got := <-received:
+		if len(got) != 1 || got[0].Action != "pull" || got[0].Target.Repository != "library/busybox" {
+			t.Fatalf("dispatch delivered %+v, want [%+v]", got, ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("sink never received dispatched batch")
+	}
+}
+
+// TestNilNotifierEmitIsNoop covers the documented contract that a nil
+// *Notifier drops events instead of panicking, so callers (e.g. main, when
+// no --notify-* flags are set) don't need to special-case "no sinks".
+func TestNilNotifierEmitIsNoop(t *testing.T) {
+	var n *Notifier
+	n.emit(Event{Action: "pull"})
+}
+
+func TestWebhookSinkRetriesOnFailureThenSucceeds(t *testing.T) {
+	var attempts int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	sink := &WebhookSink{URL: srv.URL, MaxRetries: 3}
+	if err := sink.Send(context.Background(), []Event{{Action: "push"}}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if attempts != 2 {
+		t.Fatalf("attempts = %d, want 2 (one failure, one success)", attempts)
+	}
+}
+
+func TestWebhookSinkGivesUpAfterMaxRetries(t *testing.T) {
+	var attempts int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	sink := &WebhookSink{URL: srv.URL, MaxRetries: 2}
+	if err := sink.Send(context.Background(), []Event{{Action: "push"}}); err == nil {
+		t.Fatal("Send: got nil error, want an error after exhausting retries")
+	}
+	if attempts != 3 { // initial attempt + 2 retries
+		t.Fatalf("attempts = %d, want 3", attempts)
+	}
+}
+
+// fakeBlobReader is a minimal ociregistry.BlobReader for exercising
+// notifyingRegistry without a real containerd client.
+type fakeBlobReader struct {
+	io.Reader
+	desc ociregistry.Descriptor
+}
+
+func (f *fakeBlobReader) Descriptor() ociregistry.Descriptor { return f.desc }
+func (f *fakeBlobReader) Close() error                       { return nil }
+
+// fakeRegistry is an ociregistry.Interface that only implements GetBlob,
+// leaning on the embedded Funcs for everything else, the same pattern
+// containerdRegistry itself uses.
+type fakeRegistry struct {
+	*ociregistry.Funcs
+	desc ociregistry.Descriptor
+}
+
+func (f fakeRegistry) GetBlob(ctx context.Context, repo string, digest ociregistry.Digest) (ociregistry.BlobReader, error) {
+	return &fakeBlobReader{Reader: bytes.NewReader(nil), desc: f.desc}, nil
+}
+
+// TestNotifyingRegistryEmitsPullOnGetBlob checks the wrapper wiring: a
+// successful GetBlob must enqueue a "pull" event carrying the repo and the
+// descriptor the wrapped registry returned.
+func TestNotifyingRegistryEmitsPullOnGetBlob(t *testing.T) {
+	desc := ociregistry.Descriptor{Digest: "sha256:deadbeef", Size: 42}
+	notifier := &Notifier{events: make(chan Event, 1)}
+	r := &notifyingRegistry{
+		Interface: fakeRegistry{desc: desc},
+		notifier:  notifier,
+	}
+
+	if _, err := r.GetBlob(context.Background(), "library/busybox", desc.Digest); err != nil {
+		t.Fatalf("GetBlob: %v", err)
+	}
+
+	select {
+	case ev := <-notifier.events:
+		if ev.Action != "pull" || ev.Target.Repository != "library/busybox" || ev.Target.Digest != desc.Digest {
+			t.Fatalf("got event %+v, want a pull event for library/busybox@%s", ev, desc.Digest)
+		}
+	default:
+		t.Fatal("GetBlob did not enqueue an event")
+	}
+}
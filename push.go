@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"context"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	digest "github.com/opencontainers/go-digest"
+
+	"github.com/rogpeppe/ociregistry"
+)
+
+// newIngestRef generates a ref suitable for content.WithRef. It doubles as
+// the upload session ID handed back to the client for chunked resumes, but
+// otherwise has no meaning to containerd.
+func newIngestRef(repo string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "ociregistry-" + repo + "-" + hex.EncodeToString(b), nil
+}
+
+// containerdBlobWriter adapts a containerd content.Writer (an ingest
+// session keyed by ref) to ociregistry.BlobWriter.
+type containerdBlobWriter struct {
+	client *containerd.Client
+	ref    string
+
+	w content.Writer
+}
+
+func (bw *containerdBlobWriter) Write(p []byte) (int, error) {
+	return bw.w.Write(p)
+}
+
+func (bw *containerdBlobWriter) Size() int64 {
+	return bw.w.Status().Offset
+}
+
+func (bw *containerdBlobWriter) ID() string {
+	return bw.ref
+}
+
+func (bw *containerdBlobWriter) Close() error {
+	return bw.w.Close()
+}
+
+func (bw *containerdBlobWriter) Cancel(ctx context.Context) error {
+	defer bw.w.Close()
+	return bw.client.ContentStore().Abort(ctx, bw.ref)
+}
+
+func (bw *containerdBlobWriter) Commit(ctx context.Context, size int64, digest ociregistry.Digest) (ociregistry.Descriptor, error) {
+	defer bw.w.Close()
+
+	// containerd verifies both size and digest for us on Commit (failing
+	// with an errdefs.ErrFailedPrecondition-wrapped error on mismatch), so
+	// we get content integrity checking for free here.
+	if err := bw.w.Commit(ctx, size, digest); err != nil && !errdefs.IsAlreadyExists(err) {
+		return ociregistry.Descriptor{}, err
+	}
+
+	return ociregistry.Descriptor{
+		Digest: digest,
+		Size:   size,
+	}, nil
+}
+
+func newContainerdBlobWriter(ctx context.Context, client *containerd.Client, ref string, desc ociregistry.Descriptor) (*containerdBlobWriter, error) {
+	opts := []content.WriterOpt{content.WithRef(ref)}
+	if desc.Digest != "" {
+		opts = append(opts, content.WithDescriptor(desc))
+	}
+	w, err := client.ContentStore().Writer(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &containerdBlobWriter{
+		client: client,
+		ref:    ref,
+		w:      w,
+	}, nil
+}
+
+func (r containerdRegistry) PushBlob(ctx context.Context, repo string, desc ociregistry.Descriptor, rd io.Reader) (ociregistry.Descriptor, error) {
+	ctx, repo = r.withNamespace(ctx, repo)
+	return r.pushBlob(ctx, repo, desc, rd)
+}
+
+// pushBlob is the namespace-agnostic core of PushBlob: it expects ctx to
+// already be scoped to the target containerd namespace and repo to already
+// have its namespace prefix stripped. Callers that have already called
+// withNamespace themselves (e.g. PushManifest) must use this instead of
+// PushBlob, which would otherwise re-derive the namespace from the
+// already-stripped repo and silently fall back to defaultNamespace.
+func (r containerdRegistry) pushBlob(ctx context.Context, repo string, desc ociregistry.Descriptor, rd io.Reader) (ociregistry.Descriptor, error) {
+	ref, err := newIngestRef(repo)
+	if err != nil {
+		return ociregistry.Descriptor{}, err
+	}
+	bw, err := newContainerdBlobWriter(ctx, r.client, ref, desc)
+	if err != nil {
+		return ociregistry.Descriptor{}, err
+	}
+	if _, err := io.Copy(bw, rd); err != nil {
+		bw.Cancel(ctx)
+		return ociregistry.Descriptor{}, err
+	}
+	return bw.Commit(ctx, desc.Size, desc.Digest)
+}
+
+func (r containerdRegistry) PushBlobChunked(ctx context.Context, repo string, chunk io.Reader, offset int64) (ociregistry.BlobWriter, error) {
+	ctx, repo = r.withNamespace(ctx, repo)
+
+	if offset != 0 {
+		return nil, fmt.Errorf("PushBlobChunked: non-zero offset %d for a new upload session", offset)
+	}
+	ref, err := newIngestRef(repo)
+	if err != nil {
+		return nil, err
+	}
+	bw, err := newContainerdBlobWriter(ctx, r.client, ref, ociregistry.Descriptor{})
+	if err != nil {
+		return nil, err
+	}
+	if chunk != nil {
+		if _, err := io.Copy(bw, chunk); err != nil {
+			bw.Cancel(ctx)
+			return nil, err
+		}
+	}
+	return bw, nil
+}
+
+func (r containerdRegistry) PushBlobChunkedResume(ctx context.Context, repo string, id string, offset int64) (ociregistry.BlobWriter, error) {
+	ctx, _ = r.withNamespace(ctx, repo)
+
+	// the ingest session is keyed by ref in containerd's content store, and
+	// our session "id" is just that ref, so resuming is a matter of
+	// checking its current status and reopening the same ref.
+	status, err := r.client.ContentStore().Status(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != status.Offset {
+		return nil, fmt.Errorf("upload session %s is at offset %d, not %d", id, status.Offset, offset)
+	}
+	return newContainerdBlobWriter(ctx, r.client, id, ociregistry.Descriptor{})
+}
+
+func (r containerdRegistry) MountBlob(ctx context.Context, fromRepo, toRepo string, digest ociregistry.Digest) (ociregistry.Descriptor, error) {
+	// the content store is namespaced but not repo-scoped, so "mounting"
+	// just means checking the blob already exists in toRepo's namespace.
+	ctx, _ = r.withNamespace(ctx, toRepo)
+
+	info, err := r.client.ContentStore().Info(ctx, digest)
+	if err != nil {
+		return ociregistry.Descriptor{}, err
+	}
+	return ociregistry.Descriptor{
+		Digest: digest,
+		Size:   info.Size,
+	}, nil
+}
+
+func (r containerdRegistry) PushManifest(ctx context.Context, repo string, tag string, contents []byte, mediaType string) (ociregistry.Descriptor, error) {
+	ctx, repo = r.withNamespace(ctx, repo)
+
+	desc := ociregistry.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(contents),
+		Size:      int64(len(contents)),
+	}
+	pushedDesc, err := r.pushBlob(ctx, repo, desc, bytes.NewReader(contents))
+	if err != nil {
+		return ociregistry.Descriptor{}, err
+	}
+	pushedDesc.MediaType = mediaType
+
+	if tag != "" {
+		if err := r.pushTagForDescriptor(ctx, repo, tag, pushedDesc); err != nil {
+			return ociregistry.Descriptor{}, err
+		}
+	}
+
+	return pushedDesc, nil
+}
+
+func (r containerdRegistry) PushTag(ctx context.Context, repo string, tagName string, desc ociregistry.Descriptor) error {
+	ctx, repo = r.withNamespace(ctx, repo)
+	return r.pushTagForDescriptor(ctx, repo, tagName, desc)
+}
+
+// pushTagForDescriptor makes "repo:tagName" a first-class containerd image
+// pointing at desc, creating it if new or updating the target if the tag
+// already exists (e.g. it's being moved to a new digest).
+func (r containerdRegistry) pushTagForDescriptor(ctx context.Context, repo, tagName string, desc ociregistry.Descriptor) error {
+	is := r.client.ImageService()
+	image := images.Image{
+		Name:   repo + ":" + tagName,
+		Target: desc,
+	}
+
+	if _, err := is.Create(ctx, image); err != nil {
+		if !errdefs.IsAlreadyExists(err) {
+			return err
+		}
+		if _, err := is.Update(ctx, image, "target"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r containerdRegistry) DeleteBlob(ctx context.Context, repo string, digest ociregistry.Digest) error {
+	ctx, _ = r.withNamespace(ctx, repo)
+	return r.client.ContentStore().Delete(ctx, digest)
+}
+
+func (r containerdRegistry) DeleteManifest(ctx context.Context, repo string, digest ociregistry.Digest) error {
+	ctx, _ = r.withNamespace(ctx, repo)
+	// note: this only removes the manifest's content; any tags still
+	// pointing at this digest become dangling, same as deleting content
+	// directly without first untagging the image.
+	return r.client.ContentStore().Delete(ctx, digest)
+}
+
+func (r containerdRegistry) DeleteTag(ctx context.Context, repo string, tagName string) error {
+	ctx, repo = r.withNamespace(ctx, repo)
+	return r.client.ImageService().Delete(ctx, repo+":"+tagName)
+}
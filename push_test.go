@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestNewIngestRefUniqueAndScopedToRepo covers the one piece of push.go
+// that doesn't require a real containerd client: newIngestRef both
+// identifies the ingest session to containerd and doubles as the upload
+// session ID handed back to clients for chunked resumes, so it must be
+// unique per call and must embed the repo it belongs to.
+func TestNewIngestRefUniqueAndScopedToRepo(t *testing.T) {
+	ref1, err := newIngestRef("library/busybox")
+	if err != nil {
+		t.Fatalf("newIngestRef: %v", err)
+	}
+	ref2, err := newIngestRef("library/busybox")
+	if err != nil {
+		t.Fatalf("newIngestRef: %v", err)
+	}
+
+	if ref1 == ref2 {
+		t.Fatalf("newIngestRef returned the same ref twice: %q", ref1)
+	}
+	if !strings.Contains(ref1, "library/busybox") {
+		t.Fatalf("newIngestRef(%q) = %q, want it to contain the repo name", "library/busybox", ref1)
+	}
+}
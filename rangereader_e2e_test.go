@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	digest "github.com/opencontainers/go-digest"
+
+	"github.com/rogpeppe/ociregistry"
+	"github.com/rogpeppe/ociregistry/ociserver"
+)
+
+// e2eBlobReader is a standalone ociregistry.BlobReader backed by an
+// in-memory byte slice. It supports Read/ReadAt/Seek the same way
+// containerdBlobReader does (via an embedded *io.SectionReader), so it
+// lets us drive a real HTTP Range request through ociserver without a
+// containerd client, proving ociserver actually discovers and uses that
+// support rather than only serving full bodies.
+type e2eBlobReader struct {
+	*io.SectionReader
+	desc ociregistry.Descriptor
+}
+
+func (r *e2eBlobReader) Descriptor() ociregistry.Descriptor { return r.desc }
+func (r *e2eBlobReader) Close() error                       { return nil }
+
+// e2eRangeRegistry is a minimal ociregistry.Interface, leaning on the
+// embedded Funcs for everything but GetBlob, the same pattern
+// containerdRegistry itself uses.
+type e2eRangeRegistry struct {
+	*ociregistry.Funcs
+	data []byte
+	desc ociregistry.Descriptor
+}
+
+func (r e2eRangeRegistry) GetBlob(ctx context.Context, repo string, dig ociregistry.Digest) (ociregistry.BlobReader, error) {
+	return &e2eBlobReader{
+		SectionReader: io.NewSectionReader(&readerAtBytes{r.data}, 0, int64(len(r.data))),
+		desc:          r.desc,
+	}, nil
+}
+
+// readerAtBytes adapts a byte slice to io.ReaderAt for io.NewSectionReader.
+type readerAtBytes struct{ b []byte }
+
+func (r *readerAtBytes) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(r.b)) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.b[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// TestRangeRequestServes206ThroughOCIServer is the end-to-end companion to
+// rangereader_test.go's reader-level tests: it drives a real HTTP Range
+// request through ociserver.New(registry, nil) via httptest, confirming
+// ociserver actually finds and uses containerdBlobReader-style range
+// support (rather than, say, requiring a distinct GetBlobRange method we
+// don't implement) and that the Content-Range header math matches
+// desc.Size.
+func TestRangeRequestServes206ThroughOCIServer(t *testing.T) {
+	data := []byte("the quick brown fox jumps over the lazy dog")
+	desc := ociregistry.Descriptor{
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+		MediaType: "application/octet-stream",
+	}
+	reg := e2eRangeRegistry{data: data, desc: desc}
+
+	srv := httptest.NewServer(ociserver.New(ociregistry.Interface(reg), nil))
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/v2/test/blobs/%s", srv.URL, desc.Digest), nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.Header.Set("Range", "bytes=0-2")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusPartialContent)
+	}
+	wantContentRange := fmt.Sprintf("bytes 0-2/%d", desc.Size)
+	if got := resp.Header.Get("Content-Range"); got != wantContentRange {
+		t.Fatalf("Content-Range = %q, want %q", got, wantContentRange)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if want := string(data[0:3]); string(body) != want {
+		t.Fatalf("body = %q, want %q", body, want)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	digest "github.com/opencontainers/go-digest"
+
+	"github.com/rogpeppe/ociregistry"
+)
+
+// fakeReaderAt stands in for containerd's content.ReaderAt so we can
+// exercise containerdBlobReader's range support without a real content
+// store.
+type fakeReaderAt struct {
+	data []byte
+}
+
+func (f *fakeReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *fakeReaderAt) Close() error { return nil }
+func (f *fakeReaderAt) Size() int64  { return int64(len(f.data)) }
+
+func newTestBlobReader(data []byte) *containerdBlobReader {
+	return &containerdBlobReader{
+		desc:     ociregistry.Descriptor{Size: int64(len(data))},
+		readerAt: &fakeReaderAt{data: data},
+	}
+}
+
+// newTestBlobReaderWithDigest is like newTestBlobReader but sets desc.Digest
+// so the ReadAt/Seek path has something to verify against.
+func newTestBlobReaderWithDigest(data []byte, d ociregistry.Digest) *containerdBlobReader {
+	br := newTestBlobReader(data)
+	br.desc.Digest = d
+	return br
+}
+
+// TestContainerdBlobReaderRangePathVerifiesFullCoverage guards against
+// chunk0-6's ReadAt/Seek support silently bypassing chunk0-4's digest
+// verification: a client that happens to fetch the whole blob through the
+// range-capable path (e.g. a "bytes=0-" request, or Seek(0) followed by a
+// full Read) must still get verified.
+func TestContainerdBlobReaderRangePathVerifiesFullCoverage(t *testing.T) {
+	data := []byte("the quick brown fox jumps over the lazy dog")
+	want := digest.FromBytes(data)
+	br := newTestBlobReaderWithDigest(data, want)
+
+	if _, err := br.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	got, err := io.ReadAll(br)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(got) != string(data) {
+		t.Fatalf("got %q, want %q", got, data)
+	}
+
+	// a second Read past EOF must re-run verification (now against a
+	// complete, matching hash) without error.
+	n, err := br.Read(make([]byte, 1))
+	if n != 0 || err != io.EOF {
+		t.Fatalf("Read past end = (%d, %v), want (0, io.EOF)", n, err)
+	}
+}
+
+// TestContainerdBlobReaderRangePathDetectsCorruption is the failure-mode
+// counterpart of the above: a full-coverage read through the range path
+// whose bytes don't hash to the expected digest must surface a
+// *VerifyError, not silently succeed.
+func TestContainerdBlobReaderRangePathDetectsCorruption(t *testing.T) {
+	data := []byte("the quick brown fox jumps over the lazy dog")
+	wrong := digest.FromBytes([]byte("not the data that was actually stored"))
+	br := newTestBlobReaderWithDigest(data, wrong)
+
+	if _, err := br.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	_, err := io.ReadAll(br)
+	var verr *VerifyError
+	if !errors.As(err, &verr) {
+		t.Fatalf("ReadAll err = %v, want *VerifyError", err)
+	}
+	if verr.Want != wrong {
+		t.Fatalf("VerifyError.Want = %v, want %v", verr.Want, wrong)
+	}
+}
+
+// TestContainerdBlobReaderRangePathSkipsPartialVerification confirms a
+// genuine partial range (the normal case for HTTP Range requests) is left
+// unverified rather than flagged as an integrity failure, since we only
+// ever saw part of the blob's bytes.
+func TestContainerdBlobReaderRangePathSkipsPartialVerification(t *testing.T) {
+	data := []byte("the quick brown fox jumps over the lazy dog")
+	wrong := digest.FromBytes([]byte("irrelevant, since we never read the whole blob"))
+	br := newTestBlobReaderWithDigest(data, wrong)
+
+	buf := make([]byte, 3)
+	n, err := br.ReadAt(buf, int64(len(data)-3))
+	if err != nil && err != io.EOF {
+		t.Fatalf("ReadAt: %v", err)
+	}
+	if string(buf[:n]) != "dog" {
+		t.Fatalf("got %q, want %q", buf[:n], "dog")
+	}
+}
+
+// TestContainerdBlobReaderReadAtExactSizeWithoutEOF covers the gap where a
+// single ReadAt sized exactly to the remaining bytes legally returns
+// (n, nil) instead of (n, io.EOF) per the io.ReaderAt contract - our own
+// fakeReaderAt reproduces this - so checkRangeVerification must trigger
+// off the read reaching desc.Size, not just off a literal io.EOF.
+func TestContainerdBlobReaderReadAtExactSizeWithoutEOF(t *testing.T) {
+	data := []byte("the quick brown fox jumps over the lazy dog")
+	wrong := digest.FromBytes([]byte("not the data that was actually stored"))
+	br := newTestBlobReaderWithDigest(data, wrong)
+
+	buf := make([]byte, len(data))
+	n, err := br.ReadAt(buf, 0)
+	if n != len(data) {
+		t.Fatalf("ReadAt = %d bytes, want %d", n, len(data))
+	}
+	var verr *VerifyError
+	if !errors.As(err, &verr) {
+		t.Fatalf("ReadAt err = %v, want *VerifyError (exact-size read without io.EOF must still verify)", err)
+	}
+}
+
+func TestContainerdBlobReaderReadAtMultiRange(t *testing.T) {
+	data := []byte("the quick brown fox jumps over the lazy dog")
+	br := newTestBlobReader(data)
+
+	// requested out of order, as a client retrying several failed ranges
+	// in whatever order they complete might do.
+	cases := []struct {
+		name string
+		off  int64
+		n    int
+		want string
+	}{
+		{"end range", int64(len(data) - 3), 3, "dog"},
+		{"first range", 0, 9, "the quick"},
+		{"middle range", 10, 5, "brown"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			buf := make([]byte, c.n)
+			n, err := br.ReadAt(buf, c.off)
+			if err != nil && err != io.EOF {
+				t.Fatalf("ReadAt(off=%d, len=%d): %v", c.off, c.n, err)
+			}
+			if got := string(buf[:n]); got != c.want {
+				t.Fatalf("ReadAt(off=%d, len=%d) = %q, want %q", c.off, c.n, got, c.want)
+			}
+		})
+	}
+}
+
+func TestContainerdBlobReaderSeekOutOfOrder(t *testing.T) {
+	data := []byte("0123456789")
+	br := newTestBlobReader(data)
+
+	if _, err := br.Seek(8, io.SeekStart); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	tail := make([]byte, 2)
+	if _, err := io.ReadFull(br, tail); err != nil {
+		t.Fatalf("Read after Seek(8): %v", err)
+	}
+	if string(tail) != "89" {
+		t.Fatalf("got %q, want %q", tail, "89")
+	}
+
+	if _, err := br.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	head := make([]byte, 3)
+	if _, err := io.ReadFull(br, head); err != nil {
+		t.Fatalf("Read after Seek(0): %v", err)
+	}
+	if string(head) != "012" {
+		t.Fatalf("got %q, want %q", head, "012")
+	}
+
+	// Content-Range math in ociserver relies on Seek/Read respecting
+	// desc.Size exactly; seeking right to the end must yield io.EOF on
+	// the next read rather than silently returning extra bytes.
+	if _, err := br.Seek(int64(len(data)), io.SeekStart); err != nil {
+		t.Fatalf("Seek to end: %v", err)
+	}
+	n, err := br.Read(make([]byte, 1))
+	if n != 0 || err != io.EOF {
+		t.Fatalf("Read past end = (%d, %v), want (0, io.EOF)", n, err)
+	}
+}
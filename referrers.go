@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/namespaces"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/rogpeppe/ociregistry"
+)
+
+// referrersIndexMediaTypes are the media types a decoded blob must declare
+// for its "subject" reference to be indexed while walking the content
+// store; anything else can't possibly be a referrer.
+var referrersIndexMediaTypes = map[string]bool{
+	images.MediaTypeDockerSchema2Manifest:     true,
+	images.MediaTypeDockerSchema2ManifestList: true,
+	ocispec.MediaTypeImageManifest:            true,
+	ocispec.MediaTypeImageIndex:               true,
+}
+
+// referrerManifest is just enough of a manifest/index to build a subject
+// index from; we deliberately don't decode layers/config, since we only
+// care about mediaType, artifactType, subject and annotations.
+type referrerManifest struct {
+	MediaType    string                  `json:"mediaType"`
+	ArtifactType string                  `json:"artifactType"`
+	Subject      *ociregistry.Descriptor `json:"subject"`
+	Annotations  map[string]string       `json:"annotations"`
+}
+
+// referrersCache lazily builds and caches a subject-digest -> referrers index
+// per containerd namespace by walking that namespace's content store,
+// invalidating whenever the set of digests it sees changes (a blob added or
+// removed since the last walk, not merely the count - a push that deletes
+// one referrer and adds a different one in the same window must still be
+// treated as a generation change even though the total is unchanged).
+type referrersCache struct {
+	client *containerd.Client
+
+	mu  sync.Mutex
+	nss map[string]*nsReferrersIndex
+}
+
+type nsReferrersIndex struct {
+	seen  map[digest.Digest]struct{} // digests observed on the last walk, used as a generation marker
+	index map[ociregistry.Digest][]ociregistry.Descriptor
+}
+
+func newReferrersCache(client *containerd.Client) *referrersCache {
+	return &referrersCache{client: client, nss: map[string]*nsReferrersIndex{}}
+}
+
+func (c *referrersCache) get(ctx context.Context, digest ociregistry.Digest) ([]ociregistry.Descriptor, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ns, _ := namespaces.Namespace(ctx)
+	nsIndex := c.nss[ns]
+	if nsIndex == nil {
+		nsIndex = &nsReferrersIndex{}
+		c.nss[ns] = nsIndex
+	}
+
+	stale, err := nsIndex.isStale(ctx, c.client)
+	if err != nil {
+		return nil, err
+	}
+	if stale {
+		if err := nsIndex.rebuild(ctx, c.client); err != nil {
+			return nil, err
+		}
+	}
+	return nsIndex.index[digest], nil
+}
+
+func (ni *nsReferrersIndex) isStale(ctx context.Context, client *containerd.Client) (bool, error) {
+	if ni.index == nil {
+		return true, nil
+	}
+	seen := map[digest.Digest]struct{}{}
+	err := client.ContentStore().Walk(ctx, func(info content.Info) error {
+		seen[info.Digest] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return !sameDigestSet(seen, ni.seen), nil
+}
+
+// sameDigestSet reports whether a and b contain exactly the same digests.
+// Comparing sets (rather than just their sizes) catches a blob being
+// deleted and a different one added in the same window, which leaves the
+// count unchanged but still means the index is stale.
+func sameDigestSet(a, b map[digest.Digest]struct{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for d := range a {
+		if _, ok := b[d]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (ni *nsReferrersIndex) rebuild(ctx context.Context, client *containerd.Client) error {
+	cs := client.ContentStore()
+	index := map[ociregistry.Digest][]ociregistry.Descriptor{}
+	seen := map[digest.Digest]struct{}{}
+
+	err := cs.Walk(ctx, func(info content.Info) error {
+		seen[info.Digest] = struct{}{}
+
+		ra, err := cs.ReaderAt(ctx, ociregistry.Descriptor{Digest: info.Digest})
+		if err != nil {
+			// a blob can legitimately disappear mid-walk (GC, concurrent
+			// delete); just skip it rather than failing the whole index.
+			return nil
+		}
+		defer ra.Close()
+
+		var m referrerManifest
+		if err := json.NewDecoder(io.LimitReader(content.NewReader(ra), maxManifestSize)).Decode(&m); err != nil {
+			return nil
+		}
+		if !referrersIndexMediaTypes[m.MediaType] || m.Subject == nil {
+			return nil
+		}
+
+		index[m.Subject.Digest] = append(index[m.Subject.Digest], ociregistry.Descriptor{
+			MediaType:    m.MediaType,
+			Digest:       info.Digest,
+			Size:         info.Size,
+			ArtifactType: m.ArtifactType,
+			Annotations:  m.Annotations,
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	ni.index = index
+	ni.seen = seen
+	return nil
+}
+
+// GetReferrers filters the namespace's referrers by artifactType itself,
+// since containerd's content store has no such filter built in. The
+// OCI-Filters-Applied response header that tells clients the filter was
+// actually applied is ociserver's own responsibility - it sets that
+// directly from whether the request's artifactType query parameter was
+// non-empty, so there's nothing for us to signal back through the
+// returned iterator.
+func (r containerdRegistry) GetReferrers(ctx context.Context, repo string, digest ociregistry.Digest, artifactType string) (ociregistry.Iter[ociregistry.Descriptor], error) {
+	ctx, _ = r.withNamespace(ctx, repo)
+
+	referrers, err := r.referrers.get(ctx, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	if artifactType == "" {
+		return ociregistry.SliceIter[ociregistry.Descriptor](referrers), nil
+	}
+
+	filtered := make([]ociregistry.Descriptor, 0, len(referrers))
+	for _, d := range referrers {
+		if d.ArtifactType == artifactType {
+			filtered = append(filtered, d)
+		}
+	}
+	return ociregistry.SliceIter[ociregistry.Descriptor](filtered), nil
+}
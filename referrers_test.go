@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	digest "github.com/opencontainers/go-digest"
+)
+
+// TestSameDigestSetCatchesSwap guards against the chunk0-2 staleness bug:
+// a push that deletes one referrer and adds a different one in the same
+// window leaves the blob count unchanged, so a count-based generation
+// marker would miss it. isStale compares the actual digest sets instead.
+func TestSameDigestSetCatchesSwap(t *testing.T) {
+	a := digest.FromString("a")
+	b := digest.FromString("b")
+	c := digest.FromString("c")
+
+	before := map[digest.Digest]struct{}{a: {}, b: {}}
+	after := map[digest.Digest]struct{}{a: {}, c: {}} // b removed, c added: same count
+
+	if sameDigestSet(before, after) {
+		t.Fatalf("sameDigestSet(%v, %v) = true, want false (b was swapped for c)", before, after)
+	}
+}
+
+func TestSameDigestSetUnchanged(t *testing.T) {
+	a := digest.FromString("a")
+	b := digest.FromString("b")
+
+	before := map[digest.Digest]struct{}{a: {}, b: {}}
+	after := map[digest.Digest]struct{}{a: {}, b: {}}
+
+	if !sameDigestSet(before, after) {
+		t.Fatalf("sameDigestSet(%v, %v) = false, want true", before, after)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"hash"
+	"io"
+
+	digest "github.com/opencontainers/go-digest"
+
+	"github.com/rogpeppe/ociregistry"
+)
+
+// maxManifestSize bounds how much of a candidate manifest/index we'll
+// decode looking for a few small leading fields (mediaType, artifactType,
+// subject, ...), so a maliciously large but otherwise valid JSON blob can't
+// exhaust memory before we get to the bytes we actually care about.
+const maxManifestSize = 4 << 20 // 4MiB
+
+// VerifyError is returned by containerdBlobReader once all bytes of a blob
+// have been read, when the bytes actually read don't hash to the digest
+// (or match the size) that was requested, so callers can tell integrity
+// failures apart from ordinary I/O errors.
+type VerifyError struct {
+	Want digest.Digest
+	Got  string
+	Size int64
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("content digest mismatch: want %s, got %s (size %d)", e.Want, e.Got, e.Size)
+}
+
+// verifyingReader hashes bytes as they're read and, once the underlying
+// reader reports io.EOF, checks the accumulated hash (and size, if known)
+// against the expected descriptor before letting the EOF through.
+type verifyingReader struct {
+	r    io.Reader
+	h    hash.Hash
+	want ociregistry.Digest
+	size int64 // 0 means unknown/unchecked
+
+	read int64
+}
+
+func newVerifyingReader(r io.Reader, desc ociregistry.Descriptor) io.Reader {
+	return &verifyingReader{
+		r:    r,
+		h:    desc.Digest.Algorithm().Hash(),
+		want: desc.Digest,
+		size: desc.Size,
+	}
+}
+
+func (vr *verifyingReader) Read(p []byte) (int, error) {
+	n, err := vr.r.Read(p)
+	if n > 0 {
+		vr.h.Write(p[:n])
+		vr.read += int64(n)
+	}
+	if err == io.EOF {
+		got := digest.NewDigest(vr.want.Algorithm(), vr.h)
+		if got != vr.want || (vr.size != 0 && vr.read != vr.size) {
+			return n, &VerifyError{Want: vr.want, Got: got.String(), Size: vr.read}
+		}
+	}
+	return n, err
+}